@@ -0,0 +1,47 @@
+package mux
+
+import (
+	"io"
+	"sync"
+)
+
+// buffer is an unbounded, thread-safe queue of bytes written by the
+// session loop and drained by Channel.Read. Closing it (via eof) makes
+// any subsequent Read return io.EOF once the queued bytes are drained.
+type buffer struct {
+	cond   *sync.Cond
+	buf    []byte
+	closed bool
+}
+
+func newBuffer() *buffer {
+	return &buffer{cond: sync.NewCond(new(sync.Mutex))}
+}
+
+func (b *buffer) write(p []byte) {
+	b.cond.L.Lock()
+	defer b.cond.L.Unlock()
+	b.buf = append(b.buf, p...)
+	b.cond.Broadcast()
+}
+
+func (b *buffer) eof() {
+	b.cond.L.Lock()
+	defer b.cond.L.Unlock()
+	b.closed = true
+	b.cond.Broadcast()
+}
+
+func (b *buffer) Read(p []byte) (int, error) {
+	b.cond.L.Lock()
+	defer b.cond.L.Unlock()
+	for len(b.buf) == 0 {
+		if b.closed {
+			return 0, io.EOF
+		}
+		b.cond.Wait()
+	}
+	n := copy(p, b.buf)
+	b.buf = b.buf[n:]
+	return n, nil
+}
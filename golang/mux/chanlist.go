@@ -0,0 +1,64 @@
+package mux
+
+import "sync"
+
+// chanList is a thread-safe registry of channels keyed by local id.
+// Ids are reused once a channel is removed, mirroring how SSH mux
+// implementations keep channel tables compact.
+type chanList struct {
+	sync.Mutex
+	chans []*channel
+}
+
+// add places ch in the first free slot and returns the id it was
+// assigned.
+func (c *chanList) add(ch *channel) uint32 {
+	c.Lock()
+	defer c.Unlock()
+
+	for i, existing := range c.chans {
+		if existing == nil {
+			c.chans[i] = ch
+			return uint32(i)
+		}
+	}
+	c.chans = append(c.chans, ch)
+	return uint32(len(c.chans) - 1)
+}
+
+// getChan looks up a channel by local id, returning nil if it is
+// unknown or has already been removed.
+func (c *chanList) getChan(id uint32) *channel {
+	c.Lock()
+	defer c.Unlock()
+
+	if int(id) >= len(c.chans) {
+		return nil
+	}
+	return c.chans[id]
+}
+
+func (c *chanList) remove(id uint32) {
+	c.Lock()
+	defer c.Unlock()
+
+	if int(id) < len(c.chans) {
+		c.chans[id] = nil
+	}
+}
+
+// dropAll removes and returns every live channel, used when the
+// session's connection dies.
+func (c *chanList) dropAll() []*channel {
+	c.Lock()
+	defer c.Unlock()
+
+	var ret []*channel
+	for _, ch := range c.chans {
+		if ch != nil {
+			ret = append(ret, ch)
+		}
+	}
+	c.chans = nil
+	return ret
+}
@@ -0,0 +1,252 @@
+package mux
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/manifold/qtalk/golang/mux/codec"
+)
+
+// channel is the concrete implementation of Channel.
+type channel struct {
+	ctx context.Context
+
+	session *session
+
+	direction channelDirection
+
+	localId, remoteId uint32
+
+	remoteWin window
+	myWindow  uint32
+
+	winMu       sync.Mutex
+	winConsumed uint32
+
+	maxIncomingPayload uint32
+	maxRemotePayload   uint32
+
+	// compressionAlgo is the codec.Compression* algorithm negotiated
+	// with the remote side at open time; codec.CompressionNone means
+	// payloads are always sent uncompressed.
+	compressionAlgo byte
+	minCompressSize int
+
+	compressMu   sync.Mutex
+	compressor   *qlzCompressor
+	decompressor *qlzDecompressor
+
+	pending *buffer
+	msg     chan codec.Message
+
+	requests chan *Request
+	replies  requestQueue
+
+	packetBuf []byte
+
+	// stateMu guards closed against the read loop sending to ch.msg or
+	// ch.requests concurrently with close() closing them.
+	stateMu   sync.Mutex
+	closed    bool
+	closeOnce sync.Once
+}
+
+// handle processes a message addressed to this channel, called from
+// the session's read loop.
+func (ch *channel) handle(msg codec.Message) error {
+	switch msg := msg.(type) {
+	case *codec.DataMessage:
+		data := msg.Data
+		if compressed, algo := codec.ParseDataFlags(msg.Flags); compressed {
+			d, err := ch.decompress(algo, data)
+			if err != nil {
+				codec.PutDataBuffer(msg.Data)
+				return err
+			}
+			data = d
+		} else {
+			ch.trackPlaintext(data)
+		}
+		ch.pending.write(data)
+		codec.PutDataBuffer(msg.Data)
+		return nil
+
+	case *codec.WindowAdjustMessage:
+		ch.remoteWin.add(msg.AdditionalBytes)
+		return nil
+
+	case *codec.EOFMessage:
+		ch.pending.eof()
+		return nil
+
+	case *codec.CloseMessage:
+		ch.close()
+		return nil
+
+	case *codec.RequestMessage:
+		return ch.session.handleRequest(ch, msg)
+
+	case *codec.RequestReplyMessage:
+		if !ch.replies.deliver(msg) {
+			return fmt.Errorf("qmux: unsolicited request reply on channel %d", ch.localId)
+		}
+		return nil
+
+	default:
+		ch.stateMu.Lock()
+		defer ch.stateMu.Unlock()
+		if ch.closed {
+			return nil
+		}
+		select {
+		case ch.msg <- msg:
+		case <-ch.ctx.Done():
+		}
+		return nil
+	}
+}
+
+// close releases the channel's local resources. It does not notify the
+// remote side; use Close for that.
+func (ch *channel) close() {
+	ch.closeOnce.Do(func() {
+		ch.stateMu.Lock()
+		ch.closed = true
+		close(ch.msg)
+		close(ch.requests)
+		ch.stateMu.Unlock()
+
+		ch.pending.eof()
+		ch.remoteWin.close()
+		ch.replies.close()
+		ch.session.chanList.remove(ch.localId)
+	})
+}
+
+func (ch *channel) Read(data []byte) (int, error) {
+	n, err := ch.pending.Read(data)
+	if n > 0 {
+		if adjErr := ch.adjustWindow(uint32(n)); adjErr != nil {
+			return n, adjErr
+		}
+	}
+	return n, err
+}
+
+// windowAdjustFraction is the fraction of myWindow that must be
+// consumed by Read before a WindowAdjustMessage replenishes it,
+// batching adjustments instead of sending one per Read.
+const windowAdjustFraction = 2
+
+// adjustWindow accounts for n bytes drained from the incoming buffer,
+// sending a WindowAdjustMessage once enough of myWindow is consumed.
+func (ch *channel) adjustWindow(n uint32) error {
+	ch.winMu.Lock()
+	ch.winConsumed += n
+	adjust := uint32(0)
+	if ch.winConsumed >= ch.myWindow/windowAdjustFraction {
+		adjust = ch.winConsumed
+		ch.winConsumed = 0
+	}
+	ch.winMu.Unlock()
+
+	if adjust == 0 {
+		return nil
+	}
+	return ch.session.codec.Encode(codec.WindowAdjustMessage{
+		ChannelID:       ch.remoteId,
+		AdditionalBytes: adjust,
+	})
+}
+
+func (ch *channel) Write(data []byte) (int, error) {
+	var written int
+	for len(data) > 0 {
+		want := uint32(len(data))
+		if want > ch.maxRemotePayload {
+			want = ch.maxRemotePayload
+		}
+
+		n := ch.remoteWin.reserve(want)
+		if n == 0 {
+			return written, io.EOF
+		}
+
+		packet := data[:n]
+		wire, algo := packet, codec.CompressionNone
+		if ch.compressionAlgo != codec.CompressionNone {
+			// compress always runs, even below minCompressSize, so the
+			// compressor's window advances by every packet written —
+			// matching the decompressor, which (via trackPlaintext)
+			// advances by every packet received whether or not it was
+			// actually sent compressed. minCompressSize only gates
+			// whether the compressed form is used on the wire.
+			if c, err := ch.compress(packet); err == nil && len(packet) >= ch.minCompressSize && len(c) < len(packet) {
+				wire, algo = c, ch.compressionAlgo
+			}
+		}
+
+		if err := ch.session.codec.Encode(codec.DataMessage{
+			ChannelID: ch.remoteId,
+			Length:    uint32(len(wire)),
+			Flags:     codec.DataFlags(algo != codec.CompressionNone, algo),
+			Data:      wire,
+		}); err != nil {
+			return written, err
+		}
+
+		data = data[n:]
+		written += int(n)
+	}
+	return written, nil
+}
+
+func (ch *channel) Close() error {
+	defer ch.close()
+	return ch.session.codec.Encode(codec.CloseMessage{ChannelID: ch.remoteId})
+}
+
+func (ch *channel) CloseWrite() error {
+	return ch.session.codec.Encode(codec.EOFMessage{ChannelID: ch.remoteId})
+}
+
+// SendRequest sends an out-of-band request on this channel, as
+// distinct from a Session.SendRequest which targets no channel in
+// particular. If wantReply is true, it blocks for the matching reply
+// and returns an error if the remote side reported failure.
+func (ch *channel) SendRequest(name string, wantReply bool, payload []byte) ([]byte, error) {
+	var waiter chan *codec.RequestReplyMessage
+	if wantReply {
+		waiter = ch.replies.push()
+	}
+
+	if err := ch.session.codec.Encode(codec.RequestMessage{
+		ChannelID: ch.remoteId,
+		WantReply: wantReply,
+		Name:      name,
+		Payload:   payload,
+	}); err != nil {
+		return nil, err
+	}
+
+	if !wantReply {
+		return nil, nil
+	}
+
+	reply := <-waiter
+	if reply == nil {
+		return nil, ErrRequestAborted
+	}
+	if !reply.Success {
+		return reply.Payload, fmt.Errorf("qmux: request %q failed", name)
+	}
+	return reply.Payload, nil
+}
+
+// Requests returns the channel of incoming out-of-band requests sent
+// by the remote side on this channel.
+func (ch *channel) Requests() <-chan *Request {
+	return ch.requests
+}
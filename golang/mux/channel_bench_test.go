@@ -0,0 +1,85 @@
+package mux
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/manifold/qtalk/golang/mux/codec"
+)
+
+// newChannelPair opens a channel between two qmux sessions connected
+// over a net.Pipe, with compression disabled so the benchmark
+// isolates the wire-framing/buffer-pooling path from compress_test.go's
+// compression one.
+func newChannelPair(b *testing.B) (client, server Channel, teardown func()) {
+	b.Helper()
+
+	connA, connB := net.Pipe()
+	ctx := context.Background()
+	opts := SessionOptions{Compression: CompressionOff}
+
+	srvSession := NewSession(ctx, connB, opts)
+	cliSession := NewSession(ctx, connA, opts)
+
+	accepted := make(chan Channel, 1)
+	go func() {
+		ch, err := srvSession.Accept()
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		accepted <- ch
+	}()
+
+	cliChan, err := cliSession.Open("bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return cliChan, <-accepted, func() {
+		cliSession.Close()
+		srvSession.Close()
+	}
+}
+
+// BenchmarkChannelThroughputSaturated keeps a channel's writer running
+// continuously in the background so there is always a DataMessage in
+// flight, while the benchmark loop drains it as fast as it can. This
+// exercises readPacket's io.ReadFull framing and pooled DataMessage
+// buffers under sustained load, the case the short-read/pooling fix
+// targets.
+func BenchmarkChannelThroughputSaturated(b *testing.B) {
+	client, server, teardown := newChannelPair(b)
+	defer teardown()
+
+	payload := make([]byte, codec.DefaultMaxChannelPacket)
+
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := client.Write(payload); err != nil {
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, len(payload))
+	b.SetBytes(int64(len(payload)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := io.ReadFull(server, buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	close(stop)
+	client.Close()
+}
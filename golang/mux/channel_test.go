@@ -0,0 +1,108 @@
+package mux
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// newSessionPair opens a channel between two qmux sessions connected
+// over a net.Pipe, with compression disabled so tests exercise the
+// framing/flow-control path directly.
+func newSessionPair(t *testing.T) (clientSess, serverSess Session, client, server Channel) {
+	t.Helper()
+
+	connA, connB := net.Pipe()
+	ctx := context.Background()
+	opts := SessionOptions{Compression: CompressionOff}
+
+	serverSess = NewSession(ctx, connB, opts)
+	clientSess = NewSession(ctx, connA, opts)
+
+	accepted := make(chan Channel, 1)
+	go func() {
+		ch, err := serverSess.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- ch
+	}()
+
+	var err error
+	client, err = clientSess.Open("test")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	select {
+	case server = <-accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for Accept")
+	}
+
+	return clientSess, serverSess, client, server
+}
+
+func TestChannelRoundTrip(t *testing.T) {
+	clientSess, serverSess, client, server := newSessionPair(t)
+	defer clientSess.Close()
+	defer serverSess.Close()
+
+	want := []byte("hello over qmux")
+	if _, err := client.Write(want); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	got := make([]byte, len(want))
+	if _, err := server.Read(got); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestSendRequestReply(t *testing.T) {
+	clientSess, serverSess, client, server := newSessionPair(t)
+	defer clientSess.Close()
+	defer serverSess.Close()
+
+	go func() {
+		req := <-server.Requests()
+		if req.Name != "ping" {
+			return
+		}
+		req.Reply(true, []byte("pong"))
+	}()
+
+	reply, err := client.SendRequest("ping", true, nil)
+	if err != nil {
+		t.Fatalf("SendRequest: %v", err)
+	}
+	if string(reply) != "pong" {
+		t.Fatalf("got reply %q, want %q", reply, "pong")
+	}
+}
+
+// TestCloseVsInboundMessage drives Channel.Close concurrently with the
+// peer still sending channel requests, reproducing the race between
+// close() closing ch.requests and the read loop's handleRequest
+// sending to it.
+func TestCloseVsInboundMessage(t *testing.T) {
+	clientSess, serverSess, client, server := newSessionPair(t)
+	defer clientSess.Close()
+	defer serverSess.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			server.SendRequest("poke", false, nil)
+		}
+	}()
+
+	client.Close()
+	<-done
+}
@@ -0,0 +1,63 @@
+package codec
+
+import "io"
+
+// Default wire limits shared by the codecs in this package. A Codec is
+// free to report different values from Limits if its framing imposes
+// different bounds.
+const (
+	DefaultMinPacketSize    = 9
+	DefaultMaxPacketSize    = 1 << 31
+	DefaultMaxChannelPacket = 1 << 15
+)
+
+// Limits describes the wire constraints a Codec imposes.
+type Limits struct {
+	// MinPacketSize and MaxPacketSize bound the MaxPacketSize a channel
+	// may advertise during open; a session rejects opens outside this
+	// range.
+	MinPacketSize uint32
+	MaxPacketSize uint32
+
+	// MaxChannelPacket is the largest payload the codec will place in a
+	// single DataMessage. A session's default channel window is sized
+	// as a multiple of this.
+	MaxChannelPacket uint32
+}
+
+// Codec frames and serializes Messages onto an underlying stream. A
+// Session uses exactly one Codec for both directions, so an
+// implementation is free to share state (e.g. a single buffered
+// reader) between Encode and Decode.
+type Codec interface {
+	Encode(msg Message) error
+	Decode() (Message, error)
+
+	// Limits reports the wire constraints this codec imposes.
+	Limits() Limits
+}
+
+// QMuxV1 is qmux's original wire format: a one-byte message tag
+// followed by a fixed-size header and, for a few message types, a
+// variable-length tail. It is the default Codec.
+type QMuxV1 struct {
+	enc *Encoder
+	dec *Decoder
+}
+
+// NewQMuxV1 returns a QMuxV1 codec that reads and writes rw.
+func NewQMuxV1(rw io.ReadWriter) *QMuxV1 {
+	return &QMuxV1{enc: NewEncoder(rw), dec: NewDecoder(rw)}
+}
+
+func (c *QMuxV1) Encode(msg Message) error { return c.enc.Encode(msg) }
+
+func (c *QMuxV1) Decode() (Message, error) { return c.dec.Decode() }
+
+func (c *QMuxV1) Limits() Limits {
+	return Limits{
+		MinPacketSize:    DefaultMinPacketSize,
+		MaxPacketSize:    DefaultMaxPacketSize,
+		MaxChannelPacket: DefaultMaxChannelPacket,
+	}
+}
@@ -0,0 +1,47 @@
+package codec
+
+// Compression algorithm identifiers. These appear as a bitmask in
+// OpenMessage/OpenConfirmMessage.SupportedCompression (see
+// CompressionBit) and, singly, packed into DataMessage.Flags.
+const (
+	CompressionNone byte = 0
+
+	// CompressionQLZ is qmux's built-in LZ77-family payload codec. It is
+	// not the real LZ4 wire format: only the two ends of a qmux session
+	// need to agree with each other, not interoperate with external LZ4
+	// tooling.
+	CompressionQLZ  byte = 1
+	CompressionZstd byte = 2
+)
+
+const (
+	dataFlagCompressed = 1 << 0
+	dataFlagAlgoShift  = 1
+	dataFlagAlgoMask   = 0x7
+)
+
+// DataFlags packs whether a DataMessage payload is compressed and, if
+// so, which algorithm was used into a single flags byte.
+func DataFlags(compressed bool, algo byte) uint8 {
+	if !compressed {
+		return 0
+	}
+	return dataFlagCompressed | (algo&dataFlagAlgoMask)<<dataFlagAlgoShift
+}
+
+// ParseDataFlags is the inverse of DataFlags.
+func ParseDataFlags(flags uint8) (compressed bool, algo byte) {
+	compressed = flags&dataFlagCompressed != 0
+	algo = (flags >> dataFlagAlgoShift) & dataFlagAlgoMask
+	return
+}
+
+// CompressionBit returns algo's bit in the SupportedCompression
+// bitmask. CompressionNone has no bit of its own: a zero bitmask
+// already means "nothing supported".
+func CompressionBit(algo byte) byte {
+	if algo == CompressionNone {
+		return 0
+	}
+	return 1 << (algo - 1)
+}
@@ -8,121 +8,172 @@ import (
 )
 
 type Decoder struct {
-	r io.Reader
+	r     io.Reader
+	limit uint32
 	sync.Mutex
 }
 
 func NewDecoder(r io.Reader) *Decoder {
-	return &Decoder{r: r}
+	return &Decoder{r: r, limit: DefaultMaxChannelPacket}
 }
 
 func (dec *Decoder) Decode() (Message, error) {
 	dec.Lock()
 	defer dec.Unlock()
 
-	packet, err := readPacket(dec.r)
-	if err != nil {
-		return nil, err
-	}
-
-	return decode(packet)
+	return readPacket(dec.r, dec.limit)
 }
 
-func readPacket(c io.Reader) ([]byte, error) {
-	msgNum := make([]byte, 1)
-	_, err := c.Read(msgNum)
-	if err != nil {
+// readPacket reads one wire message from c and decodes it. The tag and
+// fixed-size header are read into a pooled scratch buffer that never
+// leaves this function; io.ReadFull is used throughout since c may be
+// a reader (TLS, a pipe, a small kernel buffer) that returns partial
+// reads. DataMessage's variable-length payload is the exception: it is
+// read into a buffer drawn from the size-classed pool in pool.go,
+// which the caller must return via PutDataBuffer once done with it.
+//
+// Every variable-length field is checked against limit before its
+// buffer is allocated, so a corrupt or adversarial length prefix fails
+// with a decode error instead of attempting a multi-gigabyte alloc.
+func readPacket(c io.Reader, limit uint32) (Message, error) {
+	hdrPtr := headerPool.Get().(*[]byte)
+	defer headerPool.Put(hdrPtr)
+	hdr := *hdrPtr
+
+	if _, err := io.ReadFull(c, hdr[:1]); err != nil {
 		return nil, err
 	}
+	tag := hdr[0]
 
-	rest := make([]byte, payloadSizes[msgNum[0]])
-	_, err = c.Read(rest)
-	if err != nil {
+	size, ok := payloadSizes[tag]
+	if !ok {
+		return nil, fmt.Errorf("qmux: unexpected message type %d", tag)
+	}
+	rest := hdr[1 : 1+size]
+	if _, err := io.ReadFull(c, rest); err != nil {
 		return nil, err
 	}
 
-	packet := append(msgNum, rest...)
+	switch tag {
+	case msgChannelOpen:
+		typeLen := binary.BigEndian.Uint32(rest[13:17])
+		if typeLen > limit {
+			return nil, fmt.Errorf("qmux: channel type length %d exceeds limit %d", typeLen, limit)
+		}
+		channelType := make([]byte, typeLen)
+		if _, err := io.ReadFull(c, channelType); err != nil {
+			return nil, err
+		}
+		return &OpenMessage{
+			SenderID:             binary.BigEndian.Uint32(rest[0:4]),
+			WindowSize:           binary.BigEndian.Uint32(rest[4:8]),
+			MaxPacketSize:        binary.BigEndian.Uint32(rest[8:12]),
+			SupportedCompression: rest[12],
+			ChannelType:          string(channelType),
+		}, nil
 
-	if msgNum[0] == msgChannelData {
-		dataSize := binary.BigEndian.Uint32(rest[4:8])
-		data := make([]byte, dataSize)
-		_, err := c.Read(data)
-		if err != nil {
+	case msgChannelOpenFailure:
+		messageLen := binary.BigEndian.Uint32(rest[8:12])
+		if messageLen > limit {
+			return nil, fmt.Errorf("qmux: open-failure message length %d exceeds limit %d", messageLen, limit)
+		}
+		message := make([]byte, messageLen)
+		if _, err := io.ReadFull(c, message); err != nil {
 			return nil, err
 		}
+		return &OpenFailureMessage{
+			ChannelID: binary.BigEndian.Uint32(rest[0:4]),
+			Reason:    binary.BigEndian.Uint32(rest[4:8]),
+			Message:   string(message),
+		}, nil
 
-		packet = append(packet, data...)
-	}
+	case msgChannelData:
+		length := binary.BigEndian.Uint32(rest[4:8])
+		if length > limit {
+			return nil, fmt.Errorf("qmux: data length %d exceeds limit %d", length, limit)
+		}
+		data := getDataBuffer(int(length))
+		if _, err := io.ReadFull(c, data); err != nil {
+			return nil, err
+		}
+		return &DataMessage{
+			ChannelID: binary.BigEndian.Uint32(rest[0:4]),
+			Length:    length,
+			Flags:     rest[8],
+			Data:      data,
+		}, nil
+
+	case msgRequest:
+		nameLen := binary.BigEndian.Uint32(rest[6:10])
+		payloadLen := binary.BigEndian.Uint32(rest[10:14])
+		if uint64(nameLen)+uint64(payloadLen) > uint64(limit) {
+			return nil, fmt.Errorf("qmux: request name+payload length %d exceeds limit %d", uint64(nameLen)+uint64(payloadLen), limit)
+		}
+		tail := make([]byte, nameLen+payloadLen)
+		if _, err := io.ReadFull(c, tail); err != nil {
+			return nil, err
+		}
+		return &RequestMessage{
+			ChannelID: binary.BigEndian.Uint32(rest[0:4]),
+			Global:    rest[4] != 0,
+			WantReply: rest[5] != 0,
+			Name:      string(tail[:nameLen]),
+			Payload:   tail[nameLen:],
+		}, nil
+
+	case msgRequestReply:
+		payloadLen := binary.BigEndian.Uint32(rest[6:10])
+		if payloadLen > limit {
+			return nil, fmt.Errorf("qmux: request-reply payload length %d exceeds limit %d", payloadLen, limit)
+		}
+		tail := make([]byte, payloadLen)
+		if _, err := io.ReadFull(c, tail); err != nil {
+			return nil, err
+		}
+		return &RequestReplyMessage{
+			ChannelID: binary.BigEndian.Uint32(rest[0:4]),
+			Global:    rest[4] != 0,
+			Success:   rest[5] != 0,
+			Payload:   tail,
+		}, nil
 
-	return packet, nil
+	default:
+		return decodeFixed(tag, rest)
+	}
 }
 
-func decode(packet []byte) (Message, error) {
-	var msg Message
-	switch packet[0] {
-	case msgChannelOpen:
-		msg = new(OpenMessage)
-	case msgChannelData:
-		msg = new(DataMessage)
+// decodeFixed decodes the message types whose wire representation is
+// nothing but the fixed-size header already read into rest.
+func decodeFixed(tag byte, rest []byte) (Message, error) {
+	switch tag {
 	case msgChannelOpenConfirm:
-		msg = new(OpenConfirmMessage)
-	case msgChannelOpenFailure:
-		msg = new(OpenFailureMessage)
+		return &OpenConfirmMessage{
+			ChannelID:            binary.BigEndian.Uint32(rest[0:4]),
+			SenderID:             binary.BigEndian.Uint32(rest[4:8]),
+			WindowSize:           binary.BigEndian.Uint32(rest[8:12]),
+			MaxPacketSize:        binary.BigEndian.Uint32(rest[12:16]),
+			SupportedCompression: rest[16],
+		}, nil
+
 	case msgChannelWindowAdjust:
-		msg = new(WindowAdjustMessage)
+		return &WindowAdjustMessage{
+			ChannelID:       binary.BigEndian.Uint32(rest[0:4]),
+			AdditionalBytes: binary.BigEndian.Uint32(rest[4:8]),
+		}, nil
+
 	case msgChannelEOF:
-		msg = new(EOFMessage)
+		return &EOFMessage{ChannelID: binary.BigEndian.Uint32(rest[0:4])}, nil
+
 	case msgChannelClose:
-		msg = new(CloseMessage)
-	default:
-		return nil, fmt.Errorf("qmux: unexpected message type %d", packet[0])
-	}
-	if err := Unmarshal(packet, msg); err != nil {
-		return nil, err
-	}
-	// fmt.Println(">>", msg)
-	return msg, nil
-}
+		return &CloseMessage{ChannelID: binary.BigEndian.Uint32(rest[0:4])}, nil
+
+	case msgPing:
+		return &PingMessage{Nonce: binary.BigEndian.Uint64(rest[0:8])}, nil
 
-func Unmarshal(b []byte, v interface{}) error {
-	switch msg := v.(type) {
-	case *OpenMessage:
-		msg.SenderID = binary.BigEndian.Uint32(b[1:5])
-		msg.WindowSize = binary.BigEndian.Uint32(b[5:9])
-		msg.MaxPacketSize = binary.BigEndian.Uint32(b[9:13])
-		return nil
-
-	case *OpenConfirmMessage:
-		msg.ChannelID = binary.BigEndian.Uint32(b[1:5])
-		msg.SenderID = binary.BigEndian.Uint32(b[5:9])
-		msg.WindowSize = binary.BigEndian.Uint32(b[9:13])
-		msg.MaxPacketSize = binary.BigEndian.Uint32(b[13:17])
-		return nil
-
-	case *OpenFailureMessage:
-		msg.ChannelID = binary.BigEndian.Uint32(b[1:5])
-		return nil
-
-	case *WindowAdjustMessage:
-		msg.ChannelID = binary.BigEndian.Uint32(b[1:5])
-		msg.AdditionalBytes = binary.BigEndian.Uint32(b[5:9])
-		return nil
-
-	case *DataMessage:
-		msg.ChannelID = binary.BigEndian.Uint32(b[1:5])
-		msg.Length = binary.BigEndian.Uint32(b[5:9])
-		msg.Data = b[9:]
-		return nil
-
-	case *EOFMessage:
-		msg.ChannelID = binary.BigEndian.Uint32(b[1:5])
-		return nil
-
-	case *CloseMessage:
-		msg.ChannelID = binary.BigEndian.Uint32(b[1:5])
-		return nil
+	case msgPong:
+		return &PongMessage{Nonce: binary.BigEndian.Uint64(rest[0:8])}, nil
 
 	default:
-		return fmt.Errorf("qmux: unmarshal not supported for value %#v", v)
+		return nil, fmt.Errorf("qmux: unexpected message type %d", tag)
 	}
 }
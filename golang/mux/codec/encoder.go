@@ -0,0 +1,138 @@
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+type Encoder struct {
+	w io.Writer
+	sync.Mutex
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+func (enc *Encoder) Encode(msg Message) error {
+	enc.Lock()
+	defer enc.Unlock()
+
+	b, err := Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = enc.w.Write(b)
+	return err
+}
+
+func Marshal(msg Message) ([]byte, error) {
+	switch m := msg.(type) {
+	case OpenMessage:
+		channelType := []byte(m.ChannelType)
+		b := make([]byte, 1+payloadSizes[msgChannelOpen]+len(channelType))
+		b[0] = msgChannelOpen
+		binary.BigEndian.PutUint32(b[1:5], m.SenderID)
+		binary.BigEndian.PutUint32(b[5:9], m.WindowSize)
+		binary.BigEndian.PutUint32(b[9:13], m.MaxPacketSize)
+		b[13] = m.SupportedCompression
+		binary.BigEndian.PutUint32(b[14:18], uint32(len(channelType)))
+		copy(b[18:], channelType)
+		return b, nil
+
+	case OpenConfirmMessage:
+		b := make([]byte, 1+payloadSizes[msgChannelOpenConfirm])
+		b[0] = msgChannelOpenConfirm
+		binary.BigEndian.PutUint32(b[1:5], m.ChannelID)
+		binary.BigEndian.PutUint32(b[5:9], m.SenderID)
+		binary.BigEndian.PutUint32(b[9:13], m.WindowSize)
+		binary.BigEndian.PutUint32(b[13:17], m.MaxPacketSize)
+		b[17] = m.SupportedCompression
+		return b, nil
+
+	case OpenFailureMessage:
+		message := []byte(m.Message)
+		b := make([]byte, 1+payloadSizes[msgChannelOpenFailure]+len(message))
+		b[0] = msgChannelOpenFailure
+		binary.BigEndian.PutUint32(b[1:5], m.ChannelID)
+		binary.BigEndian.PutUint32(b[5:9], m.Reason)
+		binary.BigEndian.PutUint32(b[9:13], uint32(len(message)))
+		copy(b[13:], message)
+		return b, nil
+
+	case WindowAdjustMessage:
+		b := make([]byte, 1+payloadSizes[msgChannelWindowAdjust])
+		b[0] = msgChannelWindowAdjust
+		binary.BigEndian.PutUint32(b[1:5], m.ChannelID)
+		binary.BigEndian.PutUint32(b[5:9], m.AdditionalBytes)
+		return b, nil
+
+	case DataMessage:
+		b := make([]byte, 1+payloadSizes[msgChannelData]+len(m.Data))
+		b[0] = msgChannelData
+		binary.BigEndian.PutUint32(b[1:5], m.ChannelID)
+		binary.BigEndian.PutUint32(b[5:9], m.Length)
+		b[9] = m.Flags
+		copy(b[10:], m.Data)
+		return b, nil
+
+	case EOFMessage:
+		b := make([]byte, 1+payloadSizes[msgChannelEOF])
+		b[0] = msgChannelEOF
+		binary.BigEndian.PutUint32(b[1:5], m.ChannelID)
+		return b, nil
+
+	case CloseMessage:
+		b := make([]byte, 1+payloadSizes[msgChannelClose])
+		b[0] = msgChannelClose
+		binary.BigEndian.PutUint32(b[1:5], m.ChannelID)
+		return b, nil
+
+	case RequestMessage:
+		name := []byte(m.Name)
+		b := make([]byte, 1+payloadSizes[msgRequest]+len(name)+len(m.Payload))
+		b[0] = msgRequest
+		binary.BigEndian.PutUint32(b[1:5], m.ChannelID)
+		putBool(b[5:6], m.Global)
+		putBool(b[6:7], m.WantReply)
+		binary.BigEndian.PutUint32(b[7:11], uint32(len(name)))
+		binary.BigEndian.PutUint32(b[11:15], uint32(len(m.Payload)))
+		copy(b[15:], name)
+		copy(b[15+len(name):], m.Payload)
+		return b, nil
+
+	case RequestReplyMessage:
+		b := make([]byte, 1+payloadSizes[msgRequestReply]+len(m.Payload))
+		b[0] = msgRequestReply
+		binary.BigEndian.PutUint32(b[1:5], m.ChannelID)
+		putBool(b[5:6], m.Global)
+		putBool(b[6:7], m.Success)
+		binary.BigEndian.PutUint32(b[7:11], uint32(len(m.Payload)))
+		copy(b[11:], m.Payload)
+		return b, nil
+
+	case PingMessage:
+		b := make([]byte, 1+payloadSizes[msgPing])
+		b[0] = msgPing
+		binary.BigEndian.PutUint64(b[1:9], m.Nonce)
+		return b, nil
+
+	case PongMessage:
+		b := make([]byte, 1+payloadSizes[msgPong])
+		b[0] = msgPong
+		binary.BigEndian.PutUint64(b[1:9], m.Nonce)
+		return b, nil
+
+	default:
+		return nil, fmt.Errorf("qmux: marshal not supported for value %#v", msg)
+	}
+}
+
+func putBool(b []byte, v bool) {
+	if v {
+		b[0] = 1
+	}
+}
@@ -0,0 +1,126 @@
+package codec
+
+// Message tags. Each wire packet begins with one of these as its first
+// byte.
+const (
+	msgChannelOpen         = 100
+	msgChannelOpenConfirm  = 101
+	msgChannelOpenFailure  = 102
+	msgChannelWindowAdjust = 103
+	msgChannelData         = 104
+	msgChannelEOF          = 105
+	msgChannelClose        = 106
+	msgRequest             = 107
+	msgRequestReply        = 108
+	msgPing                = 109
+	msgPong                = 110
+)
+
+// payloadSizes gives the fixed-size portion of each message's payload,
+// i.e. everything after the one-byte tag and before any
+// variable-length tail (such as DataMessage's Data).
+var payloadSizes = map[byte]int{
+	// msgChannelOpen and msgChannelOpenFailure carry variable-length
+	// tails (ChannelType and Message respectively); these sizes cover
+	// only the fixed header fields used to learn the tail length. See
+	// readPacket.
+	msgChannelOpen:         17,
+	msgChannelOpenConfirm:  17,
+	msgChannelOpenFailure:  12,
+	msgChannelWindowAdjust: 8,
+	msgChannelData:         9,
+	msgChannelEOF:          4,
+	msgChannelClose:        4,
+	// msgRequest and msgRequestReply carry variable-length tails (name
+	// and/or payload); these sizes cover only the fixed header fields
+	// used to learn the tail lengths. See readPacket.
+	msgRequest:      14,
+	msgRequestReply: 10,
+	msgPing:         8,
+	msgPong:         8,
+}
+
+// Message is implemented by every qmux wire message. Channel reports
+// the message's channel id and whether the message is channel-scoped
+// at all; global messages (such as OpenMessage, which precedes the
+// existence of a channel id on the opening side) report isChan == false.
+type Message interface {
+	Channel() (id uint32, isChan bool)
+}
+
+// OpenMessage requests a new channel be opened. It carries no
+// ChannelID since the sender hasn't been assigned the remote one yet;
+// SenderID is the id the sender will use to refer to this channel.
+// SupportedCompression is a bitmask (see CompressionBit) of the
+// payload compression algorithms the sender can decode. ChannelType is
+// an application-defined hint (analogous to SSH's channel type) that a
+// Session's OpenFilter can key its accept/reject decision on; it is
+// opaque to qmux itself.
+type OpenMessage struct {
+	SenderID             uint32
+	WindowSize           uint32
+	MaxPacketSize        uint32
+	SupportedCompression byte
+	ChannelType          string
+}
+
+func (m OpenMessage) Channel() (uint32, bool) { return 0, false }
+
+// OpenConfirmMessage is sent in reply to an OpenMessage to accept it.
+// SupportedCompression has the same meaning as on OpenMessage.
+type OpenConfirmMessage struct {
+	ChannelID            uint32
+	SenderID             uint32
+	WindowSize           uint32
+	MaxPacketSize        uint32
+	SupportedCompression byte
+}
+
+func (m OpenConfirmMessage) Channel() (uint32, bool) { return m.ChannelID, true }
+
+// OpenFailureMessage is sent in reply to an OpenMessage to reject it.
+// Reason is one of the reason codes below (mirroring SSH's
+// SSH_MSG_CHANNEL_OPEN_FAILURE, RFC 4254 section 5.1); Message is an
+// optional human-readable explanation.
+type OpenFailureMessage struct {
+	ChannelID uint32
+	Reason    uint32
+	Message   string
+}
+
+func (m OpenFailureMessage) Channel() (uint32, bool) { return m.ChannelID, true }
+
+// WindowAdjustMessage grants the remote side additional send window
+// on a channel.
+type WindowAdjustMessage struct {
+	ChannelID       uint32
+	AdditionalBytes uint32
+}
+
+func (m WindowAdjustMessage) Channel() (uint32, bool) { return m.ChannelID, true }
+
+// DataMessage carries a chunk of channel payload. Flags packs whether
+// Data is compressed and, if so, which algorithm was used; see
+// DataFlags/ParseDataFlags.
+type DataMessage struct {
+	ChannelID uint32
+	Length    uint32
+	Flags     uint8
+	Data      []byte
+}
+
+func (m DataMessage) Channel() (uint32, bool) { return m.ChannelID, true }
+
+// EOFMessage signals that no more data will be sent on a channel.
+type EOFMessage struct {
+	ChannelID uint32
+}
+
+func (m EOFMessage) Channel() (uint32, bool) { return m.ChannelID, true }
+
+// CloseMessage signals that a channel is being torn down.
+type CloseMessage struct {
+	ChannelID uint32
+}
+
+func (m CloseMessage) Channel() (uint32, bool) { return m.ChannelID, true }
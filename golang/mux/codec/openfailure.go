@@ -0,0 +1,15 @@
+package codec
+
+// OpenFailureMessage reason codes, mirroring the SSH_OPEN_* constants
+// from RFC 4254 section 5.1, plus two qmux-specific codes for an
+// OpenMessage whose MaxPacketSize falls outside the codec's Limits:
+// WindowTooSmall when it's below Limits.MinPacketSize, and
+// PacketTooLarge when it's above Limits.MaxPacketSize.
+const (
+	AdministrativelyProhibited uint32 = 1
+	ConnectFailed              uint32 = 2
+	UnknownChannelType         uint32 = 3
+	ResourceShortage           uint32 = 4
+	WindowTooSmall             uint32 = 5
+	PacketTooLarge             uint32 = 6
+)
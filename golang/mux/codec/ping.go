@@ -0,0 +1,17 @@
+package codec
+
+// PingMessage is a connection-level keepalive probe, not tied to any
+// channel. The receiver answers it with a PongMessage carrying the
+// same Nonce.
+type PingMessage struct {
+	Nonce uint64
+}
+
+func (m PingMessage) Channel() (uint32, bool) { return 0, false }
+
+// PongMessage answers a PingMessage.
+type PongMessage struct {
+	Nonce uint64
+}
+
+func (m PongMessage) Channel() (uint32, bool) { return 0, false }
@@ -0,0 +1,76 @@
+package codec
+
+import "sync"
+
+// maxHeaderSize is the largest fixed-size header any message tag
+// carries (OpenConfirmMessage: 17 bytes), plus the one-byte tag.
+const maxHeaderSize = 1 + 17
+
+// headerPool recycles the scratch buffer readPacket uses to read a
+// message's tag and fixed-size header. The buffer never leaves
+// readPacket; it's returned to the pool before readPacket returns.
+var headerPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, maxHeaderSize)
+		return &b
+	},
+}
+
+// dataBufferClasses are the power-of-two size classes DataMessage
+// payload buffers are pooled in, up to DefaultMaxChannelPacket (the
+// most a QMuxV1 packet will ever carry).
+var dataBufferClasses []int
+
+var dataBufferPools = make(map[int]*sync.Pool)
+
+func init() {
+	for size := 256; size <= DefaultMaxChannelPacket; size *= 2 {
+		size := size
+		dataBufferClasses = append(dataBufferClasses, size)
+		dataBufferPools[size] = &sync.Pool{
+			New: func() interface{} {
+				b := make([]byte, size)
+				return &b
+			},
+		}
+	}
+}
+
+// bufferClass returns the smallest pooled size class that holds n
+// bytes, or 0 if n exceeds every class.
+func bufferClass(n int) int {
+	for _, class := range dataBufferClasses {
+		if n <= class {
+			return class
+		}
+	}
+	return 0
+}
+
+// getDataBuffer returns a buffer of length n, drawn from the matching
+// size class's pool. Requests larger than DefaultMaxChannelPacket
+// (which a conforming peer won't send) fall back to a plain
+// allocation, and such buffers are not poolable.
+func getDataBuffer(n int) []byte {
+	class := bufferClass(n)
+	if class == 0 {
+		return make([]byte, n)
+	}
+	bufPtr := dataBufferPools[class].Get().(*[]byte)
+	return (*bufPtr)[:n]
+}
+
+// PutDataBuffer returns a DataMessage payload buffer to its size
+// class's pool once the caller is done with it (see channel.handle,
+// which calls this after copying a DataMessage's Data into the
+// channel's pending buffer). A buffer whose capacity matches no size
+// class, such as getDataBuffer's oversized fallback allocation, is
+// silently dropped.
+func PutDataBuffer(buf []byte) {
+	pool, ok := dataBufferPools[cap(buf)]
+	if !ok {
+		return
+	}
+	b := buf[:cap(buf)]
+	pool.Put(&b)
+}
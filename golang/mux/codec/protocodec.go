@@ -0,0 +1,396 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Field numbers for the generic protobuf envelope ProtoV1 uses to carry
+// every qmux message type. The envelope is hand-encoded rather than
+// generated from a .proto file, but uses the real protobuf wire format
+// (varint field tags, LEB128 integers, length-delimited bytes), so the
+// field numbers below are the schema.
+const (
+	fieldType                 = 1
+	fieldChannelID            = 2
+	fieldSenderID             = 3
+	fieldWindowSize           = 4
+	fieldMaxPacketSize        = 5
+	fieldSupportedCompression = 6
+	fieldAdditionalBytes      = 7
+	fieldLength               = 8
+	fieldFlags                = 9
+	fieldData                 = 10
+	fieldGlobal               = 11
+	fieldWantReply            = 12
+	fieldName                 = 13
+	fieldPayload              = 14
+	fieldSuccess              = 15
+	fieldNonce                = 16
+	fieldChannelType          = 17
+	fieldReason               = 18
+	fieldMessage              = 19
+)
+
+const (
+	pbVarint = 0
+	pbBytes  = 2
+)
+
+// ProtoV1 is an alternative Codec that frames each message as a
+// varint-prefixed length followed by a protobuf-encoded envelope. It
+// exists to demonstrate that Session depends only on the Codec
+// interface, not on QMuxV1's specific framing.
+type ProtoV1 struct {
+	w   io.Writer
+	r   *bufio.Reader
+	wmu sync.Mutex
+	rmu sync.Mutex
+}
+
+// NewProtoV1 returns a ProtoV1 codec that reads and writes rw.
+func NewProtoV1(rw io.ReadWriter) *ProtoV1 {
+	return &ProtoV1{w: rw, r: bufio.NewReader(rw)}
+}
+
+func (c *ProtoV1) Limits() Limits {
+	return Limits{
+		MinPacketSize:    DefaultMinPacketSize,
+		MaxPacketSize:    DefaultMaxPacketSize,
+		MaxChannelPacket: DefaultMaxChannelPacket,
+	}
+}
+
+func (c *ProtoV1) Encode(msg Message) error {
+	env, err := marshalEnvelope(msg)
+	if err != nil {
+		return err
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(env)))
+	if _, err := c.w.Write(lenBuf[:n]); err != nil {
+		return err
+	}
+	_, err = c.w.Write(env)
+	return err
+}
+
+func (c *ProtoV1) Decode() (Message, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	size, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		return nil, err
+	}
+	// MaxChannelPacket bounds the payload a message carries, so leave
+	// room for the envelope's own field tags and header fields on top.
+	const envelopeOverhead = 64
+	limit := uint64(c.Limits().MaxChannelPacket) + envelopeOverhead
+	if size > limit {
+		return nil, fmt.Errorf("qmux: protobuf envelope size %d exceeds limit %d", size, limit)
+	}
+	env := make([]byte, size)
+	if _, err := io.ReadFull(c.r, env); err != nil {
+		return nil, err
+	}
+	return unmarshalEnvelope(env)
+}
+
+func appendTag(b []byte, field, wireType int) []byte {
+	return appendUvarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendTag(b, field, pbVarint)
+	return appendUvarint(b, v)
+}
+
+func appendBoolField(b []byte, field int, v bool) []byte {
+	if !v {
+		return b
+	}
+	return appendVarintField(b, field, 1)
+}
+
+func appendBytesField(b []byte, field int, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = appendTag(b, field, pbBytes)
+	b = appendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// marshalEnvelope packs msg's fields into the generic protobuf envelope.
+func marshalEnvelope(msg Message) ([]byte, error) {
+	var b []byte
+
+	switch m := msg.(type) {
+	case OpenMessage:
+		b = appendVarintField(b, fieldType, msgChannelOpen)
+		b = appendVarintField(b, fieldSenderID, uint64(m.SenderID))
+		b = appendVarintField(b, fieldWindowSize, uint64(m.WindowSize))
+		b = appendVarintField(b, fieldMaxPacketSize, uint64(m.MaxPacketSize))
+		b = appendVarintField(b, fieldSupportedCompression, uint64(m.SupportedCompression))
+		b = appendBytesField(b, fieldChannelType, []byte(m.ChannelType))
+
+	case OpenConfirmMessage:
+		b = appendVarintField(b, fieldType, msgChannelOpenConfirm)
+		b = appendVarintField(b, fieldChannelID, uint64(m.ChannelID))
+		b = appendVarintField(b, fieldSenderID, uint64(m.SenderID))
+		b = appendVarintField(b, fieldWindowSize, uint64(m.WindowSize))
+		b = appendVarintField(b, fieldMaxPacketSize, uint64(m.MaxPacketSize))
+		b = appendVarintField(b, fieldSupportedCompression, uint64(m.SupportedCompression))
+
+	case OpenFailureMessage:
+		b = appendVarintField(b, fieldType, msgChannelOpenFailure)
+		b = appendVarintField(b, fieldChannelID, uint64(m.ChannelID))
+		b = appendVarintField(b, fieldReason, uint64(m.Reason))
+		b = appendBytesField(b, fieldMessage, []byte(m.Message))
+
+	case WindowAdjustMessage:
+		b = appendVarintField(b, fieldType, msgChannelWindowAdjust)
+		b = appendVarintField(b, fieldChannelID, uint64(m.ChannelID))
+		b = appendVarintField(b, fieldAdditionalBytes, uint64(m.AdditionalBytes))
+
+	case DataMessage:
+		b = appendVarintField(b, fieldType, msgChannelData)
+		b = appendVarintField(b, fieldChannelID, uint64(m.ChannelID))
+		b = appendVarintField(b, fieldLength, uint64(m.Length))
+		b = appendVarintField(b, fieldFlags, uint64(m.Flags))
+		b = appendBytesField(b, fieldData, m.Data)
+
+	case EOFMessage:
+		b = appendVarintField(b, fieldType, msgChannelEOF)
+		b = appendVarintField(b, fieldChannelID, uint64(m.ChannelID))
+
+	case CloseMessage:
+		b = appendVarintField(b, fieldType, msgChannelClose)
+		b = appendVarintField(b, fieldChannelID, uint64(m.ChannelID))
+
+	case RequestMessage:
+		b = appendVarintField(b, fieldType, msgRequest)
+		b = appendVarintField(b, fieldChannelID, uint64(m.ChannelID))
+		b = appendBoolField(b, fieldGlobal, m.Global)
+		b = appendBoolField(b, fieldWantReply, m.WantReply)
+		b = appendBytesField(b, fieldName, []byte(m.Name))
+		b = appendBytesField(b, fieldPayload, m.Payload)
+
+	case RequestReplyMessage:
+		b = appendVarintField(b, fieldType, msgRequestReply)
+		b = appendVarintField(b, fieldChannelID, uint64(m.ChannelID))
+		b = appendBoolField(b, fieldGlobal, m.Global)
+		b = appendBoolField(b, fieldSuccess, m.Success)
+		b = appendBytesField(b, fieldPayload, m.Payload)
+
+	case PingMessage:
+		b = appendVarintField(b, fieldType, msgPing)
+		b = appendVarintField(b, fieldNonce, m.Nonce)
+
+	case PongMessage:
+		b = appendVarintField(b, fieldType, msgPong)
+		b = appendVarintField(b, fieldNonce, m.Nonce)
+
+	default:
+		return nil, fmt.Errorf("qmux: protobuf marshal not supported for value %#v", msg)
+	}
+
+	return b, nil
+}
+
+// pbEnv holds every field the generic envelope can carry, decoded from
+// the wire before being narrowed to a concrete Message by its type tag.
+type pbEnv struct {
+	typ                  uint64
+	channelID            uint32
+	senderID             uint32
+	windowSize           uint32
+	maxPacketSize        uint32
+	supportedCompression byte
+	additionalBytes      uint32
+	length               uint32
+	flags                uint8
+	data                 []byte
+	global               bool
+	wantReply            bool
+	name                 string
+	payload              []byte
+	success              bool
+	nonce                uint64
+	channelType          string
+	reason               uint32
+	message              string
+}
+
+func (e *pbEnv) setVarint(field int, v uint64) {
+	switch field {
+	case fieldType:
+		e.typ = v
+	case fieldChannelID:
+		e.channelID = uint32(v)
+	case fieldSenderID:
+		e.senderID = uint32(v)
+	case fieldWindowSize:
+		e.windowSize = uint32(v)
+	case fieldMaxPacketSize:
+		e.maxPacketSize = uint32(v)
+	case fieldSupportedCompression:
+		e.supportedCompression = byte(v)
+	case fieldAdditionalBytes:
+		e.additionalBytes = uint32(v)
+	case fieldLength:
+		e.length = uint32(v)
+	case fieldFlags:
+		e.flags = uint8(v)
+	case fieldGlobal:
+		e.global = v != 0
+	case fieldWantReply:
+		e.wantReply = v != 0
+	case fieldSuccess:
+		e.success = v != 0
+	case fieldNonce:
+		e.nonce = v
+	case fieldReason:
+		e.reason = uint32(v)
+	}
+}
+
+func (e *pbEnv) setBytes(field int, v []byte) {
+	switch field {
+	case fieldData:
+		e.data = append([]byte(nil), v...)
+	case fieldName:
+		e.name = string(v)
+	case fieldPayload:
+		e.payload = append([]byte(nil), v...)
+	case fieldChannelType:
+		e.channelType = string(v)
+	case fieldMessage:
+		e.message = string(v)
+	}
+}
+
+// toMessage narrows the envelope to a concrete Message, returned as a
+// pointer so it matches the *codec.XxxMessage type switches used
+// throughout mux (the same convention readPacket follows for QMuxV1).
+func (e *pbEnv) toMessage() (Message, error) {
+	switch e.typ {
+	case msgChannelOpen:
+		return &OpenMessage{
+			SenderID:             e.senderID,
+			WindowSize:           e.windowSize,
+			MaxPacketSize:        e.maxPacketSize,
+			SupportedCompression: e.supportedCompression,
+			ChannelType:          e.channelType,
+		}, nil
+
+	case msgChannelOpenConfirm:
+		return &OpenConfirmMessage{
+			ChannelID:            e.channelID,
+			SenderID:             e.senderID,
+			WindowSize:           e.windowSize,
+			MaxPacketSize:        e.maxPacketSize,
+			SupportedCompression: e.supportedCompression,
+		}, nil
+
+	case msgChannelOpenFailure:
+		return &OpenFailureMessage{ChannelID: e.channelID, Reason: e.reason, Message: e.message}, nil
+
+	case msgChannelWindowAdjust:
+		return &WindowAdjustMessage{ChannelID: e.channelID, AdditionalBytes: e.additionalBytes}, nil
+
+	case msgChannelData:
+		return &DataMessage{ChannelID: e.channelID, Length: e.length, Flags: e.flags, Data: e.data}, nil
+
+	case msgChannelEOF:
+		return &EOFMessage{ChannelID: e.channelID}, nil
+
+	case msgChannelClose:
+		return &CloseMessage{ChannelID: e.channelID}, nil
+
+	case msgRequest:
+		return &RequestMessage{
+			ChannelID: e.channelID,
+			Global:    e.global,
+			WantReply: e.wantReply,
+			Name:      e.name,
+			Payload:   e.payload,
+		}, nil
+
+	case msgRequestReply:
+		return &RequestReplyMessage{
+			ChannelID: e.channelID,
+			Global:    e.global,
+			Success:   e.success,
+			Payload:   e.payload,
+		}, nil
+
+	case msgPing:
+		return &PingMessage{Nonce: e.nonce}, nil
+
+	case msgPong:
+		return &PongMessage{Nonce: e.nonce}, nil
+
+	default:
+		return nil, fmt.Errorf("qmux: unexpected protobuf message type %d", e.typ)
+	}
+}
+
+func unmarshalEnvelope(b []byte) (Message, error) {
+	var env pbEnv
+
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, fmt.Errorf("qmux: invalid protobuf tag")
+		}
+		b = b[n:]
+
+		field := int(tag >> 3)
+		wireType := int(tag & 7)
+
+		switch wireType {
+		case pbVarint:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return nil, fmt.Errorf("qmux: invalid protobuf varint")
+			}
+			b = b[n:]
+			env.setVarint(field, v)
+
+		case pbBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 {
+				return nil, fmt.Errorf("qmux: invalid protobuf length")
+			}
+			b = b[n:]
+			if uint64(len(b)) < l {
+				return nil, fmt.Errorf("qmux: truncated protobuf field %d", field)
+			}
+			env.setBytes(field, b[:l])
+			b = b[l:]
+
+		default:
+			return nil, fmt.Errorf("qmux: unsupported protobuf wire type %d", wireType)
+		}
+	}
+
+	return env.toMessage()
+}
@@ -0,0 +1,139 @@
+package codec
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+// newProtoV1Pair returns two ProtoV1 codecs wired to opposite ends of a
+// net.Pipe, so Encode on one side can be checked by Decode on the
+// other without needing a full mux session.
+func newProtoV1Pair(t *testing.T) (a, b *ProtoV1, teardown func()) {
+	t.Helper()
+	connA, connB := net.Pipe()
+	return NewProtoV1(connA), NewProtoV1(connB), func() {
+		connA.Close()
+		connB.Close()
+	}
+}
+
+// roundTrip encodes msg on one end of a ProtoV1 pair and decodes it on
+// the other, returning the decoded Message for the caller to check.
+func roundTrip(t *testing.T, msg Message) Message {
+	t.Helper()
+	a, b, teardown := newProtoV1Pair(t)
+	defer teardown()
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- a.Encode(msg) }()
+
+	got, err := b.Decode()
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	return got
+}
+
+func TestProtoV1RoundTripOpenMessage(t *testing.T) {
+	want := OpenMessage{
+		SenderID:             1,
+		WindowSize:           2,
+		MaxPacketSize:        3,
+		SupportedCompression: CompressionBit(CompressionQLZ),
+		ChannelType:          "shell",
+	}
+	got := roundTrip(t, want)
+	if !reflect.DeepEqual(got, &want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestProtoV1RoundTripOpenConfirmMessage(t *testing.T) {
+	want := OpenConfirmMessage{
+		ChannelID:            1,
+		SenderID:             2,
+		WindowSize:           3,
+		MaxPacketSize:        4,
+		SupportedCompression: CompressionBit(CompressionQLZ),
+	}
+	got := roundTrip(t, want)
+	if !reflect.DeepEqual(got, &want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestProtoV1RoundTripOpenFailureMessage(t *testing.T) {
+	want := OpenFailureMessage{ChannelID: 1, Reason: UnknownChannelType, Message: "no such type"}
+	got := roundTrip(t, want)
+	if !reflect.DeepEqual(got, &want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestProtoV1RoundTripWindowAdjustMessage(t *testing.T) {
+	want := WindowAdjustMessage{ChannelID: 1, AdditionalBytes: 4096}
+	got := roundTrip(t, want)
+	if !reflect.DeepEqual(got, &want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestProtoV1RoundTripDataMessage(t *testing.T) {
+	want := DataMessage{ChannelID: 1, Length: 3, Flags: DataFlags(true, CompressionQLZ), Data: []byte("abc")}
+	got := roundTrip(t, want)
+	if !reflect.DeepEqual(got, &want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestProtoV1RoundTripEOFMessage(t *testing.T) {
+	want := EOFMessage{ChannelID: 1}
+	got := roundTrip(t, want)
+	if !reflect.DeepEqual(got, &want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestProtoV1RoundTripCloseMessage(t *testing.T) {
+	want := CloseMessage{ChannelID: 1}
+	got := roundTrip(t, want)
+	if !reflect.DeepEqual(got, &want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestProtoV1RoundTripRequestMessage(t *testing.T) {
+	want := RequestMessage{ChannelID: 1, Global: false, WantReply: true, Name: "env", Payload: []byte("k=v")}
+	got := roundTrip(t, want)
+	if !reflect.DeepEqual(got, &want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestProtoV1RoundTripRequestReplyMessage(t *testing.T) {
+	want := RequestReplyMessage{ChannelID: 1, Global: true, Success: true, Payload: []byte("ok")}
+	got := roundTrip(t, want)
+	if !reflect.DeepEqual(got, &want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestProtoV1RoundTripPingMessage(t *testing.T) {
+	want := PingMessage{Nonce: 42}
+	got := roundTrip(t, want)
+	if !reflect.DeepEqual(got, &want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestProtoV1RoundTripPongMessage(t *testing.T) {
+	want := PongMessage{Nonce: 42}
+	got := roundTrip(t, want)
+	if !reflect.DeepEqual(got, &want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+}
@@ -0,0 +1,26 @@
+package codec
+
+// RequestMessage is an out-of-band request, analogous to SSH's global
+// and channel requests. A Global request isn't tied to any channel
+// (ChannelID is meaningless); otherwise it targets the channel named
+// by ChannelID. When WantReply is set, the receiver answers with a
+// RequestReplyMessage.
+type RequestMessage struct {
+	ChannelID uint32
+	Global    bool
+	WantReply bool
+	Name      string
+	Payload   []byte
+}
+
+func (m RequestMessage) Channel() (uint32, bool) { return m.ChannelID, !m.Global }
+
+// RequestReplyMessage answers a RequestMessage that had WantReply set.
+type RequestReplyMessage struct {
+	ChannelID uint32
+	Global    bool
+	Success   bool
+	Payload   []byte
+}
+
+func (m RequestReplyMessage) Channel() (uint32, bool) { return m.ChannelID, !m.Global }
@@ -0,0 +1,216 @@
+package mux
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/manifold/qtalk/golang/mux/codec"
+)
+
+// pickCompression chooses an algorithm from a SupportedCompression
+// bitmask that is the intersection of both peers' capabilities,
+// preferring the best one this package implements.
+func pickCompression(supported byte) byte {
+	if supported&codec.CompressionBit(codec.CompressionQLZ) != 0 {
+		return codec.CompressionQLZ
+	}
+	return codec.CompressionNone
+}
+
+// compress runs src through ch's compressor for ch.compressionAlgo,
+// lazily creating the per-channel compressor on first use so later
+// calls reuse its dictionary/history instead of starting cold.
+func (ch *channel) compress(src []byte) ([]byte, error) {
+	switch ch.compressionAlgo {
+	case codec.CompressionQLZ:
+		ch.compressMu.Lock()
+		defer ch.compressMu.Unlock()
+		if ch.compressor == nil {
+			ch.compressor = newQLZCompressor()
+		}
+		return ch.compressor.compress(src), nil
+	default:
+		return nil, fmt.Errorf("qmux: unsupported compression algorithm %d", ch.compressionAlgo)
+	}
+}
+
+// decompress is compress's inverse, called from the session's single
+// read loop, so it needs no locking of its own.
+func (ch *channel) decompress(algo byte, src []byte) ([]byte, error) {
+	switch algo {
+	case codec.CompressionQLZ:
+		if ch.decompressor == nil {
+			ch.decompressor = newQLZDecompressor()
+		}
+		return ch.decompressor.decompress(src)
+	default:
+		return nil, fmt.Errorf("qmux: unsupported compression algorithm %d", algo)
+	}
+}
+
+// trackPlaintext feeds data that was sent on this channel uncompressed
+// into ch's decompressor window, keeping it in lockstep with the
+// sender's compressor. See qlzDecompressor.track.
+func (ch *channel) trackPlaintext(data []byte) {
+	if ch.compressionAlgo != codec.CompressionQLZ {
+		return
+	}
+	if ch.decompressor == nil {
+		ch.decompressor = newQLZDecompressor()
+	}
+	ch.decompressor.track(data)
+}
+
+// qlzMinMatchLen is the shortest back-reference qlzCompressor will
+// emit.
+const qlzMinMatchLen = 4
+
+// qlzWindowSize bounds how much previously seen plaintext a
+// qlzCompressor/qlzDecompressor pair carries forward as dictionary
+// context between calls. It is capped by the wire format's 16-bit
+// match offset.
+const qlzWindowSize = 1 << 16
+
+// qlzCompressor is a minimal LZ77-family block compressor for
+// codec.CompressionQLZ: a run of (uint16 literalLen, literal bytes)
+// followed by (uint16 offset, uint16 matchLen), repeated, with a zero
+// offset terminating the stream. One is kept per channel and reused
+// across Write calls, so hist carries the trailing window forward for
+// matches against data sent in an earlier message.
+type qlzCompressor struct {
+	hist []byte
+}
+
+func newQLZCompressor() *qlzCompressor {
+	return &qlzCompressor{}
+}
+
+func (c *qlzCompressor) compress(src []byte) []byte {
+	base := len(c.hist)
+	buf := append(c.hist, src...)
+
+	var out []byte
+	n := len(buf)
+	pos := make(map[uint32]int, len(buf)/4)
+
+	// Seed the dictionary with the carried-forward history so a match
+	// below can reference plaintext sent in an earlier message, not
+	// just elsewhere in src.
+	for i := 0; i+qlzMinMatchLen <= base; i++ {
+		pos[binary.BigEndian.Uint32(buf[i:])] = i
+	}
+
+	litStart, i := base, base
+
+	for i+qlzMinMatchLen <= n {
+		key := binary.BigEndian.Uint32(buf[i:])
+		matchPos, ok := pos[key]
+		pos[key] = i
+
+		if ok && i-matchPos <= 0xFFFF {
+			matchLen := qlzMinMatchLen
+			for i+matchLen < n && buf[matchPos+matchLen] == buf[i+matchLen] && matchLen < 0xFFFF {
+				matchLen++
+			}
+
+			out = appendQLZLiteral(out, buf[litStart:i])
+			out = appendUint16(out, uint16(i-matchPos))
+			out = appendUint16(out, uint16(matchLen))
+
+			i += matchLen
+			litStart = i
+			continue
+		}
+
+		i++
+	}
+
+	out = appendQLZLiteral(out, buf[litStart:n])
+	out = append(out, 0, 0) // terminal zero offset: end of stream
+
+	c.hist = qlzTrailingWindow(buf)
+	return out
+}
+
+// qlzDecompressor is qlzCompressor's counterpart: it keeps the same
+// trailing window of previously decompressed plaintext so it can
+// resolve matches a paired qlzCompressor made into earlier messages.
+type qlzDecompressor struct {
+	hist []byte
+}
+
+func newQLZDecompressor() *qlzDecompressor {
+	return &qlzDecompressor{}
+}
+
+// track extends the window with data sent uncompressed, keeping it in
+// lockstep with the compressor's window (see channel.Write).
+func (d *qlzDecompressor) track(data []byte) {
+	d.hist = qlzTrailingWindow(append(d.hist, data...))
+}
+
+func (d *qlzDecompressor) decompress(src []byte) ([]byte, error) {
+	base := len(d.hist)
+	out := append(d.hist, make([]byte, 0, len(src))...)
+	i, n := 0, len(src)
+
+	for {
+		if i+2 > n {
+			return nil, fmt.Errorf("qmux: truncated compressed literal length")
+		}
+		litLen := int(binary.BigEndian.Uint16(src[i:]))
+		i += 2
+
+		if i+litLen > n {
+			return nil, fmt.Errorf("qmux: truncated compressed literal")
+		}
+		out = append(out, src[i:i+litLen]...)
+		i += litLen
+
+		if i+2 > n {
+			return nil, fmt.Errorf("qmux: truncated compressed match offset")
+		}
+		offset := int(binary.BigEndian.Uint16(src[i:]))
+		i += 2
+		if offset == 0 {
+			break
+		}
+
+		if i+2 > n {
+			return nil, fmt.Errorf("qmux: truncated compressed match length")
+		}
+		matchLen := int(binary.BigEndian.Uint16(src[i:]))
+		i += 2
+
+		start := len(out) - offset
+		if start < 0 {
+			return nil, fmt.Errorf("qmux: invalid compressed match offset")
+		}
+		for k := 0; k < matchLen; k++ {
+			out = append(out, out[start+k])
+		}
+	}
+
+	result := append([]byte(nil), out[base:]...)
+	d.hist = qlzTrailingWindow(out)
+	return result, nil
+}
+
+// qlzTrailingWindow returns the last qlzWindowSize bytes of buf (or all
+// of it, if shorter), copied so the returned slice doesn't keep a
+// larger backing array alive.
+func qlzTrailingWindow(buf []byte) []byte {
+	if len(buf) > qlzWindowSize {
+		buf = buf[len(buf)-qlzWindowSize:]
+	}
+	return append([]byte(nil), buf...)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendQLZLiteral(b []byte, lit []byte) []byte {
+	b = appendUint16(b, uint16(len(lit)))
+	return append(b, lit...)
+}
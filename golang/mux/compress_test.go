@@ -0,0 +1,140 @@
+package mux
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// newCompressedChannelPair opens a channel between two qmux sessions
+// connected over a net.Pipe, with compression negotiated via the
+// default SessionOptions (CompressionAuto).
+func newCompressedChannelPair(b testing.TB) (client, server Channel, teardown func()) {
+	b.Helper()
+
+	connA, connB := net.Pipe()
+	ctx := context.Background()
+
+	srvSession := NewSession(ctx, connB)
+	cliSession := NewSession(ctx, connA)
+
+	accepted := make(chan Channel, 1)
+	go func() {
+		ch, err := srvSession.Accept()
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		accepted <- ch
+	}()
+
+	cliChan, err := cliSession.Open("bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return cliChan, <-accepted, func() {
+		cliSession.Close()
+		srvSession.Close()
+	}
+}
+
+// TestCompressionRoundTrip sends a mix of payloads above and below
+// MinCompressSize over a channel pair negotiated with the default
+// CompressionAuto options, and checks every one arrives byte-for-byte
+// intact. This exercises the live qlzCompressor/qlzDecompressor pair,
+// including their carried-forward dictionary state, rather than just
+// measuring throughput.
+func TestCompressionRoundTrip(t *testing.T) {
+	client, server, teardown := newCompressedChannelPair(t)
+	defer teardown()
+
+	// done lets the reader goroutine notice the test has finished (via
+	// the deferred teardown closing the sessions, which unblocks its
+	// Read with an error) instead of calling t.Error past test
+	// completion.
+	done := make(chan struct{})
+	defer close(done)
+
+	small := bytes.Repeat([]byte("ab"), 10) // well below defaultMinCompressSize
+	large := bytes.Repeat([]byte("hello qmux compression "), 200)
+	payloads := [][]byte{small, large, small, large, small}
+
+	received := make(chan []byte, len(payloads))
+	go func() {
+		for _, p := range payloads {
+			buf := make([]byte, len(p))
+			if _, err := io.ReadFull(server, buf); err != nil {
+				select {
+				case <-done:
+				default:
+					t.Error(err)
+				}
+				return
+			}
+			select {
+			case received <- buf:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for _, p := range payloads {
+		if _, err := client.Write(p); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	for i, want := range payloads {
+		select {
+		case got := <-received:
+			if !bytes.Equal(got, want) {
+				t.Fatalf("payload %d: got %q, want %q", i, got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for payload %d", i)
+		}
+	}
+}
+
+// BenchmarkChannelWriteCompressible measures Channel.Write/Read
+// throughput for a repetitive payload sent as many small messages,
+// exercising the per-channel qlzCompressor/qlzDecompressor pair that
+// carries dictionary state across calls instead of rebuilding it from
+// scratch on every message.
+func BenchmarkChannelWriteCompressible(b *testing.B) {
+	client, server, teardown := newCompressedChannelPair(b)
+	defer teardown()
+
+	payload := make([]byte, 4096)
+	for i := range payload {
+		payload[i] = byte(i % 17)
+	}
+
+	drain := make(chan struct{})
+	go func() {
+		defer close(drain)
+		buf := make([]byte, len(payload))
+		for {
+			if _, err := io.ReadFull(server, buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	b.SetBytes(int64(len(payload)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := client.Write(payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	client.Close()
+	<-drain
+}
@@ -0,0 +1,116 @@
+package mux
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrKeepaliveTimeout is recorded as a session's error (visible via
+// Wait) when it is closed because a keepalive Ping went unanswered for
+// longer than SessionOptions.KeepaliveTimeout.
+var ErrKeepaliveTimeout = errors.New("qmux: keepalive timeout")
+
+// keepalive tracks connection liveness for a session: when it was last
+// heard from, outstanding Pings awaiting a Pong, and the most recently
+// measured round-trip time. It is always present on a session, even
+// when no KeepaliveInterval is configured, so LastActivity/LastRTT
+// stay meaningful.
+type keepalive struct {
+	mu           sync.Mutex
+	lastActivity time.Time
+	lastPingSent time.Time
+	lastRTT      time.Duration
+	nextNonce    uint64
+	outstanding  map[uint64]time.Time
+}
+
+func newKeepalive() *keepalive {
+	now := time.Now()
+	return &keepalive{
+		lastActivity: now,
+		lastPingSent: now,
+		outstanding:  make(map[uint64]time.Time),
+	}
+}
+
+// touch records that a packet was just received.
+func (k *keepalive) touch() {
+	k.mu.Lock()
+	k.lastActivity = time.Now()
+	k.mu.Unlock()
+}
+
+func (k *keepalive) idleSince() time.Duration {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return time.Since(k.lastActivity)
+}
+
+// sincePing reports how long it has been since a Ping was last sent.
+// Unlike idleSince, this is unaffected by inbound traffic (including
+// the Pong replying to that very Ping), so it gives the keepalive
+// scheduler a steady cadence instead of one that drifts with activity.
+func (k *keepalive) sincePing() time.Duration {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return time.Since(k.lastPingSent)
+}
+
+func (k *keepalive) lastActivityTime() time.Time {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.lastActivity
+}
+
+func (k *keepalive) rtt() time.Duration {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.lastRTT
+}
+
+// send records a newly sent Ping and returns its nonce.
+func (k *keepalive) send() uint64 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.nextNonce++
+	nonce := k.nextNonce
+	now := time.Now()
+	k.outstanding[nonce] = now
+	k.lastPingSent = now
+	return nonce
+}
+
+// ack records the Pong for nonce, updating lastRTT. It reports false
+// for an unknown nonce (already timed out, or a stray reply).
+func (k *keepalive) ack(nonce uint64) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	sent, ok := k.outstanding[nonce]
+	if !ok {
+		return false
+	}
+	delete(k.outstanding, nonce)
+	k.lastRTT = time.Since(sent)
+	return true
+}
+
+// pingCount returns the number of Pings sent so far.
+func (k *keepalive) pingCount() uint64 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.nextNonce
+}
+
+// expired reports whether any outstanding Ping was sent more than
+// timeout ago.
+func (k *keepalive) expired(timeout time.Duration) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, sent := range k.outstanding {
+		if time.Since(sent) > timeout {
+			return true
+		}
+	}
+	return false
+}
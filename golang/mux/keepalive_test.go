@@ -0,0 +1,120 @@
+package mux
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// blackholeConn accepts writes without complaint but never yields a
+// read, simulating a peer that receives Pings but never replies.
+type blackholeConn struct {
+	closed chan struct{}
+}
+
+func newBlackholeConn() *blackholeConn {
+	return &blackholeConn{closed: make(chan struct{})}
+}
+
+func (c *blackholeConn) Write(p []byte) (int, error) { return len(p), nil }
+
+func (c *blackholeConn) Read(p []byte) (int, error) {
+	<-c.closed
+	return 0, io.EOF
+}
+
+func (c *blackholeConn) Close() error {
+	select {
+	case <-c.closed:
+	default:
+		close(c.closed)
+	}
+	return nil
+}
+
+// TestKeepaliveReplyDoesNotSuppressNextPing pins down the scheduler
+// bug directly: touching the activity clock (as onePacket does for
+// every inbound packet) and acking a Ping (as it does for the matching
+// Pong) must not push back the next scheduled Ping the way fresh
+// traffic pushes back idleSince.
+func TestKeepaliveReplyDoesNotSuppressNextPing(t *testing.T) {
+	k := newKeepalive()
+	nonce := k.send()
+	time.Sleep(5 * time.Millisecond)
+
+	// Simulate onePacket() touching the clock for the inbound Pong.
+	k.touch()
+	k.ack(nonce)
+
+	if k.sincePing() < 5*time.Millisecond {
+		t.Fatalf("sincePing reset by inbound activity: got %v, want >= 5ms", k.sincePing())
+	}
+}
+
+// TestKeepaliveConcurrentPingPongDoesNotDeadlock drives two sessions
+// connected over a net.Pipe with a very short KeepaliveInterval, so
+// both sides' keepalive timers fire close together many times over
+// the test's run. Before onePacket replied to an inbound Ping
+// asynchronously, this reliably deadlocked both sessions: each side's
+// only reader ended up blocked writing its Pong reply while the peer
+// was blocked the same way, and the Ping count froze forever. A frozen
+// count for several consecutive samples is treated as that deadlock.
+func TestKeepaliveConcurrentPingPongDoesNotDeadlock(t *testing.T) {
+	ctx := context.Background()
+	connA, connB := net.Pipe()
+
+	opts := SessionOptions{KeepaliveInterval: 2 * time.Millisecond}
+	clientSess := NewSession(ctx, connA, opts)
+	serverSess := NewSession(ctx, connB, opts)
+	defer clientSess.Close()
+	defer serverSess.Close()
+
+	client := clientSess.(*session)
+
+	const checkInterval = 20 * time.Millisecond
+	const maxStalls = 3
+
+	deadline := time.Now().Add(500 * time.Millisecond)
+	var last uint64
+	stalls := 0
+	for time.Now().Before(deadline) {
+		time.Sleep(checkInterval)
+		count := client.keepalive.pingCount()
+		if count == last {
+			stalls++
+			if stalls >= maxStalls {
+				t.Fatalf("Ping count stalled at %d for %d consecutive checks; session likely deadlocked replying to the peer's Ping", count, maxStalls)
+			}
+		} else {
+			stalls = 0
+		}
+		last = count
+	}
+	if last == 0 {
+		t.Fatal("no Pings were ever sent")
+	}
+}
+
+func TestKeepaliveTimeout(t *testing.T) {
+	ctx := context.Background()
+
+	clientSess := NewSession(ctx, newBlackholeConn(), SessionOptions{
+		KeepaliveInterval: 10 * time.Millisecond,
+		KeepaliveTimeout:  20 * time.Millisecond,
+	})
+	defer clientSess.Close()
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- clientSess.Wait() }()
+
+	select {
+	case err := <-errCh:
+		if err != ErrKeepaliveTimeout {
+			t.Fatalf("got err %v, want %v", err, ErrKeepaliveTimeout)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for keepalive timeout to close the session")
+	}
+}
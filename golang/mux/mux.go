@@ -0,0 +1,78 @@
+// Package mux implements qmux, a connection multiplexer modeled on the
+// channel protocol used by SSH: a single underlying connection carries
+// many independent, flow-controlled byte streams ("channels"), each
+// opened and torn down explicitly rather than being tied to the
+// lifetime of the connection itself.
+package mux
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+)
+
+// Session multiplexes channels over a single underlying connection.
+type Session interface {
+	// Context returns the context the session was created with.
+	Context() context.Context
+
+	// Open starts a new channel and blocks until the remote side
+	// confirms or rejects it. hint is an application-defined
+	// channel-type string (analogous to SSH's channel type) passed to
+	// the remote side's OpenFilter; it has no meaning to qmux itself.
+	// A rejection is returned as an *OpenError.
+	Open(hint string) (Channel, error)
+
+	// Accept blocks until the remote side opens a channel, or the
+	// session closes.
+	Accept() (Channel, error)
+
+	// Close tears down the underlying connection, closing every
+	// channel.
+	Close() error
+
+	// Wait blocks until the session's connection has been closed,
+	// returning the error that caused it.
+	Wait() error
+
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+
+	// SendRequest sends a global out-of-band request, not tied to any
+	// channel. If wantReply is true, it blocks for the matching reply
+	// and returns an error if the remote side reported failure.
+	SendRequest(name string, wantReply bool, payload []byte) ([]byte, error)
+
+	// Requests returns the channel of incoming global requests sent by
+	// the remote side.
+	Requests() <-chan *Request
+
+	// LastRTT returns the round-trip time measured by the most
+	// recently acknowledged keepalive Ping, or zero if none has
+	// completed yet.
+	LastRTT() time.Duration
+
+	// LastActivity returns when the session last received a packet of
+	// any kind.
+	LastActivity() time.Time
+}
+
+// Channel is a bidirectional, flow-controlled byte stream multiplexed
+// over a Session.
+type Channel interface {
+	io.ReadWriteCloser
+
+	// CloseWrite signals EOF to the remote side without closing the
+	// channel for reading.
+	CloseWrite() error
+
+	// SendRequest sends an out-of-band request on this channel. If
+	// wantReply is true, it blocks for the matching reply and returns
+	// an error if the remote side reported failure.
+	SendRequest(name string, wantReply bool, payload []byte) ([]byte, error)
+
+	// Requests returns the channel of incoming out-of-band requests
+	// sent by the remote side on this channel.
+	Requests() <-chan *Request
+}
@@ -0,0 +1,18 @@
+package mux
+
+import "fmt"
+
+// OpenError reports why a Session.Open failed on the remote side, as
+// conveyed by a codec.OpenFailureMessage. Reason is one of the reason
+// codes in the codec package (e.g. codec.ConnectFailed).
+type OpenError struct {
+	Reason  uint32
+	Message string
+}
+
+func (e *OpenError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("qmux: channel open failed: %s (reason %d)", e.Message, e.Reason)
+	}
+	return fmt.Sprintf("qmux: channel open failed (reason %d)", e.Reason)
+}
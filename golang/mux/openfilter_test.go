@@ -0,0 +1,34 @@
+package mux
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestOpenFilterRejects(t *testing.T) {
+	connA, connB := net.Pipe()
+	ctx := context.Background()
+
+	opts := SessionOptions{
+		OpenFilter: func(ctx context.Context, hint string) (bool, uint32, string) {
+			return false, 42, "hint not allowed"
+		},
+	}
+	serverSess := NewSession(ctx, connB, opts)
+	clientSess := NewSession(ctx, connA)
+	defer clientSess.Close()
+	defer serverSess.Close()
+
+	_, err := clientSess.Open("rejected")
+	if err == nil {
+		t.Fatal("expected Open to fail")
+	}
+	openErr, ok := err.(*OpenError)
+	if !ok {
+		t.Fatalf("got error of type %T, want *OpenError", err)
+	}
+	if openErr.Reason != 42 {
+		t.Fatalf("got reason %d, want 42", openErr.Reason)
+	}
+}
@@ -0,0 +1,83 @@
+package mux
+
+import (
+	"context"
+	"time"
+
+	"github.com/manifold/qtalk/golang/mux/codec"
+)
+
+// CompressionMode selects how a Session negotiates per-message payload
+// compression with its peer.
+type CompressionMode int
+
+const (
+	// CompressionAuto negotiates the best algorithm both peers
+	// support, falling back to none if they share none. This is the
+	// zero value.
+	CompressionAuto CompressionMode = iota
+
+	// CompressionOff disables compression outright; nothing is
+	// advertised during channel open.
+	CompressionOff
+
+	// CompressionQLZ requests qmux's built-in LZ77-family compression
+	// (codec.CompressionQLZ). It is not the real LZ4 format and does not
+	// interoperate with external LZ4 tooling.
+	CompressionQLZ
+)
+
+// defaultMinCompressSize is used when SessionOptions.MinCompressSize
+// is left at its zero value.
+const defaultMinCompressSize = 256
+
+// SessionOptions configures optional Session behavior. The zero value
+// selects conservative defaults.
+type SessionOptions struct {
+	// Compression selects the payload compression policy. The zero
+	// value, CompressionAuto, negotiates automatically.
+	Compression CompressionMode
+
+	// MinCompressSize is the smallest payload, in bytes, qmux will
+	// send compressed; smaller payloads are always sent
+	// uncompressed. Zero selects defaultMinCompressSize.
+	MinCompressSize int
+
+	// KeepaliveInterval, if positive, sends a Ping each time this long
+	// has passed since the previous Ping, whether or not other packets
+	// have been received in the meantime. Zero disables keepalives.
+	KeepaliveInterval time.Duration
+
+	// KeepaliveTimeout is how long to wait for a Pong before closing
+	// the session with ErrKeepaliveTimeout. Only meaningful when
+	// KeepaliveInterval is set; zero disables the timeout check.
+	KeepaliveTimeout time.Duration
+
+	// Codec selects the wire framing used to serialize messages. The
+	// zero value uses codec.NewQMuxV1, qmux's native framing.
+	Codec codec.Codec
+
+	// OpenFilter, if set, is consulted for every inbound channel open
+	// that passes the codec's packet-size checks, before it is queued
+	// for Accept, with the remote side's Open hint. Returning accept == false rejects the open; reason and
+	// message are relayed to the remote side's Open as an *OpenError.
+	OpenFilter func(ctx context.Context, hint string) (accept bool, reason uint32, message string)
+}
+
+func (o SessionOptions) minCompressSize() int {
+	if o.MinCompressSize > 0 {
+		return o.MinCompressSize
+	}
+	return defaultMinCompressSize
+}
+
+// localCompressionBits returns the SupportedCompression bitmask this
+// session advertises during channel open, per its Compression policy.
+func (o SessionOptions) localCompressionBits() byte {
+	switch o.Compression {
+	case CompressionOff:
+		return 0
+	default:
+		return codec.CompressionBit(codec.CompressionQLZ)
+	}
+}
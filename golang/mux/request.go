@@ -0,0 +1,91 @@
+package mux
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/manifold/qtalk/golang/mux/codec"
+)
+
+// ErrRequestAborted is returned by SendRequest when its channel or
+// session closes before a reply arrives.
+var ErrRequestAborted = errors.New("qmux: channel or session closed before a reply arrived")
+
+// Request is an out-of-band request received from the remote side,
+// either on a Session (a "global" request, not tied to any channel) or
+// on a Channel. Handlers that receive a Request with WantReply set
+// must call Reply; it is a no-op otherwise.
+type Request struct {
+	Name      string
+	Payload   []byte
+	WantReply bool
+
+	global  bool
+	ch      *channel
+	session *session
+}
+
+// Reply answers the request. ok reports success or failure to the
+// sender; payload is opaque application data returned alongside it.
+func (r *Request) Reply(ok bool, payload []byte) error {
+	if !r.WantReply {
+		return nil
+	}
+
+	reply := codec.RequestReplyMessage{
+		Global:  r.global,
+		Success: ok,
+		Payload: payload,
+	}
+	if r.ch != nil {
+		reply.ChannelID = r.ch.remoteId
+	}
+	return r.session.codec.Encode(reply)
+}
+
+// requestQueue matches incoming RequestReplyMessages to SendRequest
+// callers in FIFO order. SSH-style request/reply protocols guarantee
+// replies arrive in the order requests were sent, so the oldest
+// outstanding waiter always corresponds to the next reply.
+type requestQueue struct {
+	mu      sync.Mutex
+	waiters []chan *codec.RequestReplyMessage
+}
+
+func (q *requestQueue) push() chan *codec.RequestReplyMessage {
+	ch := make(chan *codec.RequestReplyMessage, 1)
+	q.mu.Lock()
+	q.waiters = append(q.waiters, ch)
+	q.mu.Unlock()
+	return ch
+}
+
+// deliver hands msg to the oldest outstanding waiter. It reports false
+// if there was no waiter, which indicates a protocol violation by the
+// remote side (an unsolicited reply).
+func (q *requestQueue) deliver(msg *codec.RequestReplyMessage) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.waiters) == 0 {
+		return false
+	}
+	ch := q.waiters[0]
+	q.waiters = q.waiters[1:]
+	ch <- msg
+	close(ch)
+	return true
+}
+
+// close wakes every outstanding waiter with a nil reply, so a
+// SendRequest blocked waiting for one doesn't hang forever once its
+// channel or session is gone.
+func (q *requestQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, ch := range q.waiters {
+		close(ch)
+	}
+	q.waiters = nil
+}
@@ -6,22 +6,11 @@ import (
 	"io"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/manifold/qtalk/golang/mux/codec"
 )
 
-const (
-	minPacketLength = 9
-	maxPacketLength = 1 << 31
-
-	// channelMaxPacket contains the maximum number of bytes that will be
-	// sent in a single packet. As per RFC 4253, section 6.1, 32k is also
-	// the minimum.
-	channelMaxPacket = 1 << 15
-	// We follow OpenSSH here.
-	channelWindowSize = 64 * channelMaxPacket
-)
-
 // chanSize sets the amount of buffering qmux connections. This is
 // primarily for testing: setting chanSize=0 uncovers deadlocks more
 // quickly.
@@ -39,34 +28,127 @@ type session struct {
 	conn     io.ReadWriteCloser
 	chanList chanList
 
-	enc *codec.Encoder
-	dec *codec.Decoder
+	codec  codec.Codec
+	limits codec.Limits
 
 	incomingChannels chan Channel
 
+	requests      chan *Request
+	globalReplies requestQueue
+
+	opts             SessionOptions
+	localCompression byte
+	keepalive        *keepalive
+
 	errCond *sync.Cond
 	err     error
 	closeCh chan bool
+	done    chan struct{}
 }
 
 // NewSession returns a session that runs over the given connection.
-func NewSession(ctx context.Context, rwc io.ReadWriteCloser) Session {
+// opts is optional; the zero value selects conservative defaults.
+func NewSession(ctx context.Context, rwc io.ReadWriteCloser, opts ...SessionOptions) Session {
 	if rwc == nil {
 		return nil
 	}
+	var o SessionOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	c := o.Codec
+	if c == nil {
+		c = codec.NewQMuxV1(rwc)
+	}
 	s := &session{
 		ctx:              ctx,
 		conn:             rwc,
-		enc:              codec.NewEncoder(rwc),
-		dec:              codec.NewDecoder(rwc),
+		codec:            c,
+		limits:           c.Limits(),
 		incomingChannels: make(chan Channel, chanSize),
+		requests:         make(chan *Request, chanSize),
+		opts:             o,
+		localCompression: o.localCompressionBits(),
+		keepalive:        newKeepalive(),
 		errCond:          sync.NewCond(new(sync.Mutex)),
 		closeCh:          make(chan bool, 1),
+		done:             make(chan struct{}),
 	}
 	go s.loop()
+	if o.KeepaliveInterval > 0 {
+		go s.keepaliveLoop()
+	}
 	return s
 }
 
+// LastRTT returns the round-trip time measured by the most recently
+// acknowledged keepalive Ping, or zero if none has completed yet.
+func (s *session) LastRTT() time.Duration {
+	return s.keepalive.rtt()
+}
+
+// LastActivity returns when the session last received a packet of any
+// kind.
+func (s *session) LastActivity() time.Time {
+	return s.keepalive.lastActivityTime()
+}
+
+// keepaliveLoop sends a Ping once KeepaliveInterval has passed since the
+// previous Ping, and closes the session with ErrKeepaliveTimeout if a
+// Pong hasn't arrived within KeepaliveTimeout. Pings are sent whether or
+// not other packets have been received in the meantime; inbound traffic
+// does not delay the next Ping.
+func (s *session) keepaliveLoop() {
+	ticker := time.NewTicker(s.opts.KeepaliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+
+		case <-ticker.C:
+			if s.opts.KeepaliveTimeout > 0 && s.keepalive.expired(s.opts.KeepaliveTimeout) {
+				s.failKeepalive()
+				return
+			}
+			if s.keepalive.sincePing() < s.opts.KeepaliveInterval {
+				continue
+			}
+			if err := s.codec.Encode(codec.PingMessage{Nonce: s.keepalive.send()}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// sendPong replies to an inbound Ping. It runs in its own goroutine,
+// spawned from onePacket rather than awaited inline: onePacket is the
+// session's only reader, and if both peers' keepalive Pings land close
+// together, each side's reader blocking on this write (waiting for the
+// other side's Decode to drain the pipe) while the other is
+// symmetrically blocked deadlocks the session. Decoupling the write
+// from the read loop keeps a reader available on both ends.
+func (s *session) sendPong(nonce uint64) {
+	if err := s.codec.Encode(codec.PongMessage{Nonce: nonce}); err != nil {
+		s.errCond.L.Lock()
+		if s.err == nil {
+			s.err = err
+		}
+		s.errCond.L.Unlock()
+		s.conn.Close()
+	}
+}
+
+func (s *session) failKeepalive() {
+	s.errCond.L.Lock()
+	if s.err == nil {
+		s.err = ErrKeepaliveTimeout
+	}
+	s.errCond.L.Unlock()
+	s.conn.Close()
+}
+
 func (s *session) Context() context.Context {
 	return s.ctx
 }
@@ -99,6 +181,77 @@ func (s *session) Wait() error {
 	return s.err
 }
 
+// SendRequest sends a global out-of-band request, not tied to any
+// channel. If wantReply is true, it blocks for the matching reply and
+// returns an error if the remote side reported failure.
+func (s *session) SendRequest(name string, wantReply bool, payload []byte) ([]byte, error) {
+	var waiter chan *codec.RequestReplyMessage
+	if wantReply {
+		waiter = s.globalReplies.push()
+	}
+
+	if err := s.codec.Encode(codec.RequestMessage{
+		Global:    true,
+		WantReply: wantReply,
+		Name:      name,
+		Payload:   payload,
+	}); err != nil {
+		return nil, err
+	}
+
+	if !wantReply {
+		return nil, nil
+	}
+
+	reply := <-waiter
+	if reply == nil {
+		return nil, ErrRequestAborted
+	}
+	if !reply.Success {
+		return reply.Payload, fmt.Errorf("qmux: request %q failed", name)
+	}
+	return reply.Payload, nil
+}
+
+// Requests returns the channel of incoming global requests sent by the
+// remote side.
+func (s *session) Requests() <-chan *Request {
+	return s.requests
+}
+
+// handleRequest delivers an incoming RequestMessage to the appropriate
+// Requests() channel: the session's, for global requests, or the
+// owning channel's otherwise.
+func (s *session) handleRequest(ch *channel, msg *codec.RequestMessage) error {
+	req := &Request{
+		Name:      msg.Name,
+		Payload:   msg.Payload,
+		WantReply: msg.WantReply,
+		global:    msg.Global,
+		session:   s,
+		ch:        ch,
+	}
+
+	if ch == nil {
+		select {
+		case s.requests <- req:
+		case <-s.ctx.Done():
+		}
+		return nil
+	}
+
+	ch.stateMu.Lock()
+	defer ch.stateMu.Unlock()
+	if ch.closed {
+		return nil
+	}
+	select {
+	case ch.requests <- req:
+	case <-s.ctx.Done():
+	}
+	return nil
+}
+
 func (s *session) Accept() (Channel, error) {
 	// TODO: context cancel
 	select {
@@ -109,14 +262,16 @@ func (s *session) Accept() (Channel, error) {
 	}
 }
 
-func (s *session) Open() (Channel, error) {
+func (s *session) Open(hint string) (Channel, error) {
 	ch := s.newChannel(channelOutbound)
-	ch.maxIncomingPayload = channelMaxPacket
-
-	if err := s.enc.Encode(codec.OpenMessage{
-		WindowSize:    ch.myWindow,
-		MaxPacketSize: ch.maxIncomingPayload,
-		SenderID:      ch.localId,
+	ch.maxIncomingPayload = s.limits.MaxChannelPacket
+
+	if err := s.codec.Encode(codec.OpenMessage{
+		WindowSize:           ch.myWindow,
+		MaxPacketSize:        ch.maxIncomingPayload,
+		SenderID:             ch.localId,
+		SupportedCompression: s.localCompression,
+		ChannelType:          hint,
 	}); err != nil {
 		return nil, err
 	}
@@ -128,10 +283,15 @@ func (s *session) Open() (Channel, error) {
 	}
 	switch msg := m.(type) {
 	case *codec.OpenConfirmMessage:
+		ch.remoteId = msg.SenderID
+		ch.maxRemotePayload = msg.MaxPacketSize
+		ch.remoteWin.add(msg.WindowSize)
+		ch.compressionAlgo = pickCompression(s.localCompression & msg.SupportedCompression)
+		ch.minCompressSize = s.opts.minCompressSize()
 		return ch, nil
 
 	case *codec.OpenFailureMessage:
-		return nil, fmt.Errorf("qmux: channel open failed on remote side")
+		return nil, &OpenError{Reason: msg.Reason, Message: msg.Message}
 
 	default:
 		return nil, fmt.Errorf("qmux: unexpected packet in response to channel open: %v", msg)
@@ -142,10 +302,11 @@ func (s *session) newChannel(direction channelDirection) *channel {
 	ch := &channel{
 		ctx:       s.ctx,
 		remoteWin: window{Cond: sync.NewCond(new(sync.Mutex))},
-		myWindow:  channelWindowSize,
+		myWindow:  s.limits.MaxChannelPacket * 64,
 		pending:   newBuffer(),
 		direction: direction,
 		msg:       make(chan codec.Message, chanSize),
+		requests:  make(chan *Request, chanSize),
 		session:   s,
 		packetBuf: make([]byte, 0),
 	}
@@ -164,12 +325,17 @@ func (s *session) loop() {
 	for _, ch := range s.chanList.dropAll() {
 		ch.close()
 	}
+	s.globalReplies.close()
+	close(s.requests)
+	close(s.done)
 
 	s.conn.Close()
 	s.closeCh <- true
 
 	s.errCond.L.Lock()
-	s.err = err
+	if s.err == nil {
+		s.err = err
+	}
 	s.errCond.Broadcast()
 	s.errCond.L.Unlock()
 }
@@ -179,14 +345,36 @@ func (s *session) onePacket() error {
 	var err error
 	var msg codec.Message
 
-	msg, err = s.dec.Decode()
+	msg, err = s.codec.Decode()
 	if err != nil {
 		return err
 	}
+	s.keepalive.touch()
+
+	switch m := msg.(type) {
+	case *codec.PingMessage:
+		go s.sendPong(m.Nonce)
+		return nil
+	case *codec.PongMessage:
+		s.keepalive.ack(m.Nonce)
+		return nil
+	}
 
 	id, isChan := msg.Channel()
 	if !isChan {
-		return s.handleOpen(msg.(*codec.OpenMessage))
+		switch msg := msg.(type) {
+		case *codec.OpenMessage:
+			return s.handleOpen(msg)
+		case *codec.RequestMessage:
+			return s.handleRequest(nil, msg)
+		case *codec.RequestReplyMessage:
+			if !s.globalReplies.deliver(msg) {
+				return fmt.Errorf("qmux: unsolicited global request reply")
+			}
+			return nil
+		default:
+			return fmt.Errorf("qmux: unexpected global message: %v", msg)
+		}
 	}
 
 	ch := s.chanList.getChan(id)
@@ -197,25 +385,49 @@ func (s *session) onePacket() error {
 	return ch.handle(msg)
 }
 
-// handleChannelOpen schedules a channel to be Accept()ed.
+// handleOpen schedules a channel to be Accept()ed, unless the
+// open is rejected by a packet-size check or the session's OpenFilter,
+// in which case it replies with an OpenFailureMessage instead.
 func (s *session) handleOpen(msg *codec.OpenMessage) error {
-	if msg.MaxPacketSize < minPacketLength || msg.MaxPacketSize > maxPacketLength {
-		return s.enc.Encode(codec.OpenFailureMessage{
+	if msg.MaxPacketSize < s.limits.MinPacketSize {
+		return s.codec.Encode(codec.OpenFailureMessage{
 			ChannelID: msg.SenderID,
+			Reason:    codec.WindowTooSmall,
+			Message:   "qmux: requested MaxPacketSize below session minimum",
 		})
 	}
+	if msg.MaxPacketSize > s.limits.MaxPacketSize {
+		return s.codec.Encode(codec.OpenFailureMessage{
+			ChannelID: msg.SenderID,
+			Reason:    codec.PacketTooLarge,
+			Message:   "qmux: requested MaxPacketSize above session maximum",
+		})
+	}
+
+	if s.opts.OpenFilter != nil {
+		if accept, reason, message := s.opts.OpenFilter(s.ctx, msg.ChannelType); !accept {
+			return s.codec.Encode(codec.OpenFailureMessage{
+				ChannelID: msg.SenderID,
+				Reason:    reason,
+				Message:   message,
+			})
+		}
+	}
 
 	c := s.newChannel(channelInbound)
 	c.remoteId = msg.SenderID
 	c.maxRemotePayload = msg.MaxPacketSize
 	c.remoteWin.add(msg.WindowSize)
-	c.maxIncomingPayload = channelMaxPacket
+	c.maxIncomingPayload = s.limits.MaxChannelPacket
+	c.compressionAlgo = pickCompression(s.localCompression & msg.SupportedCompression)
+	c.minCompressSize = s.opts.minCompressSize()
 	s.incomingChannels <- c
 
-	return s.enc.Encode(codec.OpenConfirmMessage{
-		ChannelID:     c.remoteId,
-		SenderID:      c.localId,
-		WindowSize:    c.myWindow,
-		MaxPacketSize: c.maxIncomingPayload,
+	return s.codec.Encode(codec.OpenConfirmMessage{
+		ChannelID:            c.remoteId,
+		SenderID:             c.localId,
+		WindowSize:           c.myWindow,
+		MaxPacketSize:        c.maxIncomingPayload,
+		SupportedCompression: s.localCompression,
 	})
 }
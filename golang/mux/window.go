@@ -0,0 +1,53 @@
+package mux
+
+import "sync"
+
+// window tracks how many bytes of payload may still be sent to the
+// remote side of a channel before a WindowAdjustMessage is required.
+type window struct {
+	*sync.Cond
+	win    uint32
+	closed bool
+}
+
+// add grants additional send window, waking any writer blocked in
+// reserve.
+func (w *window) add(n uint32) bool {
+	if n == 0 {
+		return false
+	}
+	w.L.Lock()
+	w.win += n
+	w.Broadcast()
+	w.L.Unlock()
+	return true
+}
+
+// reserve blocks until at least one byte of window is available, then
+// claims up to win bytes of it and returns the amount claimed. It
+// returns 0 once close has been called, instead of blocking forever on
+// a channel or session that is never coming back.
+func (w *window) reserve(win uint32) uint32 {
+	w.L.Lock()
+	defer w.L.Unlock()
+	for w.win == 0 && !w.closed {
+		w.Wait()
+	}
+	if w.closed {
+		return 0
+	}
+	if w.win < win {
+		win = w.win
+	}
+	w.win -= win
+	return win
+}
+
+// close wakes any writer blocked in reserve, making it return 0 from
+// now on.
+func (w *window) close() {
+	w.L.Lock()
+	w.closed = true
+	w.Broadcast()
+	w.L.Unlock()
+}